@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// requestTimeout ограничивает, сколько времени воркер ждёт ответа от OpenAI
+// по одному заданию, прежде чем само задание будет отменено.
+const requestTimeout = 60 * time.Second
+
+// deadlineTimer перевооружаемый таймер, который отменяет контекст запроса по
+// истечении срока. Похоже на deadlineTimer из netstack/gonet: SetDeadline
+// просто переустанавливает внутренний time.Timer, не пересоздавая контекст.
+type deadlineTimer struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+func newDeadlineTimer(cancel context.CancelFunc) *deadlineTimer {
+	return &deadlineTimer{cancel: cancel}
+}
+
+// setDeadline (пере)вооружает таймер на d; по истечении d связанный контекст
+// отменяется.
+func (d *deadlineTimer) setDeadline(dur time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(dur, d.cancel)
+}
+
+func (d *deadlineTimer) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// taskHandle хранит средства отмены для задания, которое сейчас в работе.
+type taskHandle struct {
+	cancel context.CancelFunc
+	dt     *deadlineTimer
+}
+
+var (
+	activeTasksMu sync.Mutex
+	activeTasks   = make(map[int]*taskHandle)
+)
+
+// registerActiveTask заводит запись об отменяемом задании и вооружает его
+// дедлайн на requestTimeout.
+func registerActiveTask(taskID int) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	dt := newDeadlineTimer(cancel)
+	dt.setDeadline(requestTimeout)
+
+	activeTasksMu.Lock()
+	activeTasks[taskID] = &taskHandle{cancel: cancel, dt: dt}
+	activeTasksMu.Unlock()
+
+	return ctx
+}
+
+// unregisterActiveTask снимает задание с учёта и останавливает его таймер;
+// вызывается после завершения обработки, успешного или нет.
+func unregisterActiveTask(taskID int) {
+	activeTasksMu.Lock()
+	handle, ok := activeTasks[taskID]
+	delete(activeTasks, taskID)
+	activeTasksMu.Unlock()
+
+	if ok {
+		handle.dt.stop()
+	}
+}
+
+// cancelTaskHandler закрывает контекст активного задания, обрывая исходящий
+// запрос к OpenAI, и помечает задание как отменённое в БД.
+func cancelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	idParam := r.URL.Query().Get("id")
+	if idParam == "" {
+		sendJSONError(w, http.StatusBadRequest, "id параметр обязателен")
+		return
+	}
+
+	taskID, err := strconv.Atoi(idParam)
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, "id должен быть числом")
+		return
+	}
+
+	activeTasksMu.Lock()
+	handle, ok := activeTasks[taskID]
+	activeTasksMu.Unlock()
+
+	if ok {
+		handle.cancel()
+
+		stmt, err := db.Prepare("UPDATE tasks SET cancelled = 1, status = 'cancelled' WHERE id = ?")
+		if err != nil {
+			sendJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if _, err := stmt.Exec(taskID); err != nil {
+			sendJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Задание ещё не подхвачено воркером (registerActiveTask не вызывался),
+	// но уже стоит в очереди — отменяем его прямо в БД, пока claimNextTask
+	// не забрал его в работу. Условие status = 'pending' гарантирует, что
+	// мы не перетрём running/done/failed задание, которое просто успело
+	// завершиться между нашей проверкой activeTasks и этим запросом.
+	result, err := db.Exec("UPDATE tasks SET cancelled = 1, status = 'cancelled' WHERE id = ? AND status = 'pending'", taskID)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rowsAffected == 0 {
+		sendJSONError(w, http.StatusNotFound, "Активное задание не найдено")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+// FineTuningJob отражает строку таблицы fine_tuning_jobs и то, что о задаче
+// сообщает сам OpenAI.
+type FineTuningJob struct {
+	JobID           string `json:"job_id"`
+	BaseModel       string `json:"base_model"`
+	TrainingFile    string `json:"training_file"`
+	Status          string `json:"status"`
+	FineTunedModel  string `json:"fine_tuned_model"`
+	Hyperparameters string `json:"hyperparameters"`
+}
+
+func initializeFineTuningTable() error {
+	createTableSQL := `
+    CREATE TABLE IF NOT EXISTS fine_tuning_jobs (
+        job_id TEXT PRIMARY KEY,
+        base_model TEXT,
+        training_file TEXT,
+        status TEXT,
+        fine_tuned_model TEXT,
+        hyperparameters TEXT
+    );
+    `
+	_, err := db.Exec(createTableSQL)
+	return err
+}
+
+// openAIRequest делает обычный (не потоковый) запрос к OpenAI и возвращает
+// тело ответа, либо ошибку, если статус не 2xx.
+func openAIRequest(method, url string, body []byte) ([]byte, error) {
+	var reqBody *bytes.Buffer
+	if body != nil {
+		reqBody = bytes.NewBuffer(body)
+	} else {
+		reqBody = bytes.NewBuffer(nil)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("OpenAI вернул статус %s: %s", resp.Status, string(responseBody))
+	}
+
+	return responseBody, nil
+}
+
+func saveFineTuningJob(job FineTuningJob) error {
+	stmt, err := db.Prepare(`INSERT INTO fine_tuning_jobs
+        (job_id, base_model, training_file, status, fine_tuned_model, hyperparameters)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(job_id) DO UPDATE SET
+            status = excluded.status,
+            fine_tuned_model = excluded.fine_tuned_model`)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(job.JobID, job.BaseModel, job.TrainingFile, job.Status, job.FineTunedModel, job.Hyperparameters)
+	return err
+}
+
+func getFineTuningJob(jobID string) (*FineTuningJob, error) {
+	var job FineTuningJob
+	err := db.QueryRow(`SELECT job_id, base_model, training_file, status, fine_tuned_model, hyperparameters
+        FROM fine_tuning_jobs WHERE job_id = ?`, jobID).
+		Scan(&job.JobID, &job.BaseModel, &job.TrainingFile, &job.Status, &job.FineTunedModel, &job.Hyperparameters)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// uploadFineTuneFileHandler загружает локальный JSONL-файл с обучающими
+// примерами в OpenAI через /v1/files и возвращает присвоенный ему file id,
+// который затем передаётся в createFineTuneHandler как training_file.
+// Без этого шага у клиента не было пути от файла на диске к fine-tune —
+// только от уже загруженного куда-то id.
+func uploadFineTuneFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, "file параметр обязателен")
+		return
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("purpose", "fine-tune"); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	part, err := writer.CreateFormFile("file", header.Filename)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := writer.Close(); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req, err := http.NewRequest("POST", "https://api.openai.com/v1/files", &body)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("не удалось загрузить файл для fine-tuning", "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Error("OpenAI отклонил загрузку файла", "status", resp.Status)
+		sendJSONError(w, resp.StatusCode, string(responseBody))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBody)
+}
+
+// createFineTuneHandler запускает fine-tune на уже загруженном training_file
+// (полученном, например, через uploadFineTuneFileHandler) и сохраняет
+// метаданные задачи локально.
+func createFineTuneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TrainingFile    string `json:"training_file"`
+		BaseModel       string `json:"base_model"`
+		Hyperparameters string `json:"hyperparameters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	requestData := map[string]interface{}{
+		"training_file": req.TrainingFile,
+		"model":         req.BaseModel,
+	}
+	requestDataJSON, err := json.Marshal(requestData)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responseBody, err := openAIRequest("POST", "https://api.openai.com/v1/fine_tuning/jobs", requestDataJSON)
+	if err != nil {
+		logger.Error("не удалось создать fine-tuning задачу", "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	var openAIJob struct {
+		ID     string `json:"id"`
+		Model  string `json:"model"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(responseBody, &openAIJob); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	job := FineTuningJob{
+		JobID:           openAIJob.ID,
+		BaseModel:       req.BaseModel,
+		TrainingFile:    req.TrainingFile,
+		Status:          openAIJob.Status,
+		Hyperparameters: req.Hyperparameters,
+	}
+	if err := saveFineTuningJob(job); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// getFineTuneHandler опрашивает статус задачи у OpenAI и обновляет локальную
+// запись, прежде чем вернуть её клиенту.
+func getFineTuneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		sendJSONError(w, http.StatusBadRequest, "id параметр обязателен")
+		return
+	}
+
+	responseBody, err := openAIRequest("GET", "https://api.openai.com/v1/fine_tuning/jobs/"+jobID, nil)
+	if err != nil {
+		logger.Error("не удалось получить статус fine-tuning задачи", "job_id", jobID, "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	var openAIJob struct {
+		ID             string `json:"id"`
+		Model          string `json:"model"`
+		Status         string `json:"status"`
+		FineTunedModel string `json:"fine_tuned_model"`
+	}
+	if err := json.Unmarshal(responseBody, &openAIJob); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	existing, err := getFineTuningJob(jobID)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing == nil {
+		sendJSONError(w, http.StatusNotFound, "Fine-tuning задача не найдена")
+		return
+	}
+
+	existing.Status = openAIJob.Status
+	existing.FineTunedModel = openAIJob.FineTunedModel
+	if err := saveFineTuningJob(*existing); err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(existing)
+}
+
+// cancelFineTuneHandler отменяет fine-tuning задачу на стороне OpenAI.
+func cancelFineTuneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		sendJSONError(w, http.StatusBadRequest, "id параметр обязателен")
+		return
+	}
+
+	responseBody, err := openAIRequest("POST", "https://api.openai.com/v1/fine_tuning/jobs/"+jobID+"/cancel", []byte{})
+	if err != nil {
+		logger.Error("не удалось отменить fine-tuning задачу", "job_id", jobID, "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	existing, err := getFineTuningJob(jobID)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing != nil {
+		existing.Status = "cancelled"
+		if err := saveFineTuningJob(*existing); err != nil {
+			sendJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Write(responseBody)
+}
+
+// eventsFineTuneHandler проксирует ленту событий fine-tuning задачи без
+// какой-либо локальной обработки.
+func eventsFineTuneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		sendJSONError(w, http.StatusBadRequest, "id параметр обязателен")
+		return
+	}
+
+	responseBody, err := openAIRequest("GET", "https://api.openai.com/v1/fine_tuning/jobs/"+jobID+"/events", nil)
+	if err != nil {
+		logger.Error("не удалось получить события fine-tuning задачи", "job_id", jobID, "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBody)
+}
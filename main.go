@@ -1,17 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"io"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 type Task struct {
@@ -19,11 +20,24 @@ type Task struct {
 	ItemId   int    `json:"item_id"`
 	Prompt   string `json:"prompt"`
 	Response string `json:"response"`
+	// Model, если задан, переопределяет модель по умолчанию — например,
+	// указывает на fine-tuned модель, полученную через /fineTune/get.
+	Model string `json:"model,omitempty"`
+	// Priority выше забирается воркерами раньше; задания с одинаковым
+	// приоритетом разбираются в порядке id.
+	Priority int `json:"priority,omitempty"`
+	// Status отражает место задания в очереди: pending, running, done,
+	// failed или cancelled.
+	Status string `json:"status,omitempty"`
+	// Provider выбирает, какой LLM-бэкенд обслужит задание: openai
+	// (по умолчанию), azure, anthropic или ollama.
+	Provider string `json:"provider,omitempty"`
 }
 
 type Config struct {
-	APIKey string `json:"api_key"`
-	Port   string `json:"port"`
+	APIKey    string                    `json:"api_key"`
+	Port      string                    `json:"port"`
+	Providers map[string]ProviderConfig `json:"providers"`
 }
 
 type ErrorResponse struct {
@@ -32,9 +46,13 @@ type ErrorResponse struct {
 
 var db *sql.DB
 var maxConcurrentRequests = 10
-var requestQueue = make(chan Task, maxConcurrentRequests)
 var apiKey string
 
+// logger пишет структурированные JSON-логи вместо обычного log.Printf,
+// чтобы по task_id/provider/status можно было сопоставлять просадки
+// очереди с задержками апстрима, не грепая error.log построчно.
+var logger *slog.Logger
+
 func loadConfig(filename string) (Config, error) {
 	var config Config
 	file, err := os.Open(filename)
@@ -69,76 +87,151 @@ func initializeDB() (*sql.DB, error) {
 		return nil, err
 	}
 
+	// Эти столбцы появились позже baseline-схемы, поэтому добавляем их
+	// отдельно и игнорируем ошибку "уже существует" при повторном запуске.
+	for _, migration := range []string{
+		"ALTER TABLE tasks ADD COLUMN cancelled BOOLEAN DEFAULT 0",
+		"ALTER TABLE tasks ADD COLUMN model TEXT DEFAULT ''",
+		"ALTER TABLE tasks ADD COLUMN priority INTEGER DEFAULT 0",
+		"ALTER TABLE tasks ADD COLUMN attempts INTEGER DEFAULT 0",
+		"ALTER TABLE tasks ADD COLUMN next_run_at DATETIME DEFAULT CURRENT_TIMESTAMP",
+		"ALTER TABLE tasks ADD COLUMN last_error TEXT DEFAULT ''",
+		"ALTER TABLE tasks ADD COLUMN status TEXT DEFAULT 'pending'",
+		"ALTER TABLE tasks ADD COLUMN provider TEXT DEFAULT ''",
+	} {
+		_, _ = db.Exec(migration)
+	}
+
+	// Существующие строки из старой схемы не имеют осмысленного status —
+	// выводим его из processed/cancelled, чтобы воркеры не подобрали их
+	// повторно и не пропустили уже готовые ответы.
+	_, err = db.Exec(`UPDATE tasks SET status = 'done' WHERE processed = 1 AND (status IS NULL OR status = '')`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`UPDATE tasks SET status = 'cancelled' WHERE cancelled = 1 AND (status IS NULL OR status = '')`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`UPDATE tasks SET status = 'pending' WHERE status IS NULL OR status = ''`)
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
-func processQueue() {
-	for task := range requestQueue {
-		url := "https://api.openai.com/v1/chat/completions"
-
-		requestData := map[string]interface{}{
-			"model": "gpt-3.5-turbo",
-			"messages": []map[string]string{
-				{
-					"role":    "user",
-					"content": task.Prompt,
-				},
-			},
-			"temperature": 0.7,
-		}
-
-		requestDataJSON, err := json.Marshal(requestData)
-		if err != nil {
-			log.Printf("Ошибка при маршалинге JSON: %v", err)
-			continue
-		}
+// processTask выполняет один запрос к LLM-провайдеру от имени task. Запрос
+// привязан к отменяемому контексту: отмена через /cancelTask или истечение
+// deadline'а обрывает его без утечки горутины или соединения. Ретраи с
+// backoff'ом и учёт попыток реализованы в queue.go.
+func processTask(task Task) {
+	ctx := registerActiveTask(task.ID)
+	defer unregisterActiveTask(task.ID)
+
+	providerName := task.Provider
+	if providerName == "" {
+		providerName = "openai"
+	}
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestDataJSON))
-		if err != nil {
-			log.Printf("Ошибка при создании запроса: %v", err)
-			continue
-		}
+	provider, err := resolveProvider(providerName)
+	if err != nil {
+		logger.Error("не удалось выбрать провайдера", "task_id", task.ID, "provider", providerName, "error", err)
+		recordError("unknown_provider")
+		failTask(task, err.Error(), false)
+		return
+	}
 
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-		req.Header.Set("Content-Type", "application/json")
+	resultCh := make(chan struct {
+		response string
+		usage    Usage
+		err      error
+	}, 1)
+	start := time.Now()
+	go func() {
+		response, usage, err := provider.Complete(ctx, task.Prompt, CompletionOptions{Model: task.Model, Temperature: 0.7})
+		resultCh <- struct {
+			response string
+			usage    Usage
+			err      error
+		}{response, usage, err}
+	}()
+
+	var result struct {
+		response string
+		usage    Usage
+		err      error
+	}
+	select {
+	case <-ctx.Done():
+		logger.Warn("задание отменено или истёк дедлайн", "task_id", task.ID, "item_id", task.ItemId, "provider", providerName, "model", task.Model)
+		markTaskCancelled(task.ID)
+		return
+	case result = <-resultCh:
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Ошибка при выполнении запроса: %v", err)
-			continue
-		}
-		defer resp.Body.Close()
+	latency := time.Since(start)
+	providerLatencyHistogram.WithLabelValues(providerName).Observe(latency.Seconds())
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Ошибка HTTP статуса: %v", resp.Status)
-			continue
+	if result.err != nil {
+		retryable := true
+		var providerErr *ProviderError
+		if errors.As(result.err, &providerErr) {
+			retryable = providerErr.Retryable()
 		}
+		recordError(errorClass(result.err, retryable))
+		logger.Error("запрос к провайдеру не удался",
+			"task_id", task.ID, "item_id", task.ItemId, "provider", providerName, "model", task.Model,
+			"latency_ms", latency.Milliseconds(), "status", "error", "error", result.err)
+		failTask(task, result.err.Error(), retryable)
+		return
+	}
 
-		responseBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Ошибка при чтении ответа: %v", err)
-			continue
-		}
+	recordUsage(providerName, result.usage)
+	logger.Info("задание обработано",
+		"task_id", task.ID, "item_id", task.ItemId, "provider", providerName, "model", task.Model,
+		"latency_ms", latency.Milliseconds(), "status", "done")
 
-		task.Response = string(responseBody)
+	task.Response = result.response
+	completeTask(task)
+}
 
-		stmt, err := db.Prepare("UPDATE tasks SET response = ?, processed = 1 WHERE id = ?")
-		if err != nil {
-			log.Printf("Ошибка при обновлении задания в базе данных: %v", err)
-			continue
-		}
-		_, err = stmt.Exec(task.Response, task.ID)
-		if err != nil {
-			log.Printf("Ошибка при обновлении задания в базе данных: %v", err)
-			continue
+// errorClass классифицирует ошибку для errorCounter: по статусу ответа
+// провайдера, если он известен, иначе как сетевую.
+func errorClass(err error, retryable bool) string {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		switch {
+		case providerErr.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		case providerErr.StatusCode >= 500:
+			return "upstream_5xx"
+		default:
+			return "upstream_4xx"
 		}
 	}
+	if retryable {
+		return "network"
+	}
+	return "internal"
+}
+
+// markTaskCancelled помечает задание отменённым после срабатывания ctx.Done.
+func markTaskCancelled(taskID int) {
+	stmt, err := db.Prepare("UPDATE tasks SET status = 'cancelled', cancelled = 1 WHERE id = ?")
+	if err != nil {
+		logger.Error("не удалось пометить задание отменённым", "task_id", taskID, "error", err)
+		return
+	}
+	if _, err := stmt.Exec(taskID); err != nil {
+		logger.Error("не удалось пометить задание отменённым", "task_id", taskID, "error", err)
+	}
 }
 
 func getTaskByItemID(itemID int) (*Task, error) {
 	var task Task
-	err := db.QueryRow("SELECT id, item_id, prompt, response FROM tasks WHERE item_id = ?", itemID).Scan(&task.ID, &task.ItemId, &task.Prompt, &task.Response)
+	err := db.QueryRow("SELECT id, item_id, prompt, response, status FROM tasks WHERE item_id = ?", itemID).
+		Scan(&task.ID, &task.ItemId, &task.Prompt, &task.Response, &task.Status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -188,12 +281,14 @@ func addTaskHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stmt, err := db.Prepare("INSERT INTO tasks (prompt, item_id, response, processed) VALUES (?, ?, '', 0)")
+	stmt, err := db.Prepare(`INSERT INTO tasks
+        (prompt, item_id, response, processed, model, provider, priority, attempts, next_run_at, status)
+        VALUES (?, ?, '', 0, ?, ?, ?, 0, CURRENT_TIMESTAMP, 'pending')`)
 	if err != nil {
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	result, err := stmt.Exec(task.Prompt, task.ItemId)
+	result, err := stmt.Exec(task.Prompt, task.ItemId, task.Model, task.Provider, task.Priority)
 	if err != nil {
 		sendJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -201,10 +296,9 @@ func addTaskHandler(w http.ResponseWriter, r *http.Request) {
 
 	taskID, _ := result.LastInsertId()
 	task.ID = int(taskID)
+	task.Status = "pending"
 
-	requestQueue <- task
-
-	responseTask := Task{ID: task.ID, ItemId: task.ItemId}
+	responseTask := Task{ID: task.ID, ItemId: task.ItemId, Status: task.Status}
 	json.NewEncoder(w).Encode(responseTask)
 }
 
@@ -221,7 +315,8 @@ func getTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var task Task
-	err := db.QueryRow("SELECT id, item_id, prompt, response FROM tasks WHERE item_id=?", id).Scan(&task.ID, &task.ItemId, &task.Prompt, &task.Response)
+	err := db.QueryRow("SELECT id, item_id, prompt, response, status FROM tasks WHERE item_id=?", id).
+		Scan(&task.ID, &task.ItemId, &task.Prompt, &task.Response, &task.Status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			sendJSONError(w, http.StatusNotFound, "Task not found")
@@ -234,25 +329,6 @@ func getTaskHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(task)
 }
 
-func getUnprocessedTasks() ([]Task, error) {
-	rows, err := db.Query("SELECT id, item_id, prompt FROM tasks WHERE processed = 0")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var unprocessedTasks []Task
-	for rows.Next() {
-		var task Task
-		if err := rows.Scan(&task.ID, &task.ItemId, &task.Prompt); err != nil {
-			return nil, err
-		}
-		unprocessedTasks = append(unprocessedTasks, task)
-	}
-
-	return unprocessedTasks, nil
-}
-
 func main() {
 	var err error
 
@@ -263,6 +339,7 @@ func main() {
 	defer logfile.Close()
 
 	log.SetOutput(logfile)
+	logger = slog.New(slog.NewJSONHandler(logfile, nil))
 
 	config, err := loadConfig("config.json")
 	if err != nil {
@@ -270,6 +347,7 @@ func main() {
 	}
 
 	apiKey = config.APIKey
+	registerProviders(config)
 
 	db, err = initializeDB()
 	if err != nil {
@@ -277,30 +355,41 @@ func main() {
 	}
 	defer db.Close()
 
-	// необработанные задания
-	unprocessedTasks, err := getUnprocessedTasks()
-	if err != nil {
+	if err := initializeFineTuningTable(); err != nil {
 		log.Fatal(err)
 	}
 
+	// Задания, которые остались в статусе running после падения процесса,
+	// возвращаем в pending, чтобы их подобрал кто-то из воркеров.
+	if err := recoverInFlightTasks(); err != nil {
+		log.Fatal(err)
+	}
+
+	startQueueDepthUpdater()
+
 	var wg sync.WaitGroup
 	for i := 0; i < maxConcurrentRequests; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			processQueue()
+			runWorker()
 		}()
 	}
 
-	for _, task := range unprocessedTasks {
-		requestQueue <- task
-	}
-
-	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+	http.HandleFunc("/", instrumentHandler("/", func(w http.ResponseWriter, _ *http.Request) {
 		io.WriteString(w, "Hello!")
-	})
-	http.HandleFunc("/addTask", addTaskHandler)
-	http.HandleFunc("/getTask", getTaskHandler)
+	}))
+	http.HandleFunc("/addTask", instrumentHandler("/addTask", addTaskHandler))
+	http.HandleFunc("/getTask", instrumentHandler("/getTask", getTaskHandler))
+	http.HandleFunc("/streamTask", instrumentHandler("/streamTask", streamTaskHandler))
+	http.HandleFunc("/cancelTask", instrumentHandler("/cancelTask", cancelTaskHandler))
+	http.HandleFunc("/fineTune/uploadFile", instrumentHandler("/fineTune/uploadFile", uploadFineTuneFileHandler))
+	http.HandleFunc("/fineTune/create", instrumentHandler("/fineTune/create", createFineTuneHandler))
+	http.HandleFunc("/fineTune/get", instrumentHandler("/fineTune/get", getFineTuneHandler))
+	http.HandleFunc("/fineTune/cancel", instrumentHandler("/fineTune/cancel", cancelFineTuneHandler))
+	http.HandleFunc("/fineTune/events", instrumentHandler("/fineTune/events", eventsFineTuneHandler))
+	http.HandleFunc("/providers", instrumentHandler("/providers", providersHandler))
+	http.Handle("/metrics", metricsHandler)
 
 	fmt.Println("Сервер запущен на :" + config.Port)
 	err = http.ListenAndServe(":"+config.Port, nil)
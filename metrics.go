@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	queueDepthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chatgpt_client_queue_depth",
+		Help: "Число заданий со статусом pending в очереди.",
+	})
+
+	providerLatencyHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatgpt_client_provider_request_duration_seconds",
+		Help:    "Время ответа LLM-провайдера на один запрос.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	tokensConsumedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_client_tokens_consumed_total",
+		Help: "Токены, потраченные по данным usage в ответе провайдера.",
+	}, []string{"provider", "kind"})
+
+	httpLatencyHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatgpt_client_http_request_duration_seconds",
+		Help:    "Время обработки HTTP-запроса по маршруту.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	errorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatgpt_client_errors_total",
+		Help: "Количество ошибок по классу.",
+	}, []string{"class"})
+)
+
+// instrumentHandler оборачивает обработчик, фиксируя длительность запроса
+// к маршруту route в httpLatencyHistogram.
+func instrumentHandler(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		httpLatencyHistogram.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordUsage переносит usage-блок ответа провайдера в счётчик токенов.
+func recordUsage(provider string, usage Usage) {
+	tokensConsumedCounter.WithLabelValues(provider, "prompt").Add(float64(usage.PromptTokens))
+	tokensConsumedCounter.WithLabelValues(provider, "completion").Add(float64(usage.CompletionTokens))
+	tokensConsumedCounter.WithLabelValues(provider, "total").Add(float64(usage.TotalTokens))
+}
+
+// recordError увеличивает счётчик ошибок по классу (timeout, http_4xx,
+// http_5xx, provider и т.д.) — так операторы различают временные сбои
+// апстрима от постоянных ошибок конфигурации.
+func recordError(class string) {
+	errorCounter.WithLabelValues(class).Inc()
+}
+
+// startQueueDepthUpdater периодически опрашивает число pending-заданий в БД
+// и обновляет queueDepthGauge — это и есть "глубина очереди" теперь, когда
+// очередь целиком живёт в tasks, а не в in-memory chan Task.
+func startQueueDepthUpdater() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			var depth int
+			if err := db.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = 'pending'").Scan(&depth); err != nil {
+				logger.Error("не удалось опросить глубину очереди", "error", err)
+				continue
+			}
+			queueDepthGauge.Set(float64(depth))
+		}
+	}()
+}
+
+// metricsHandler отдаёт метрики Prometheus на /metrics.
+var metricsHandler = promhttp.Handler()
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+// CompletionOptions передаёт провайдеру то немногое, что у него может
+// запросить общий код очереди — конкретная модель сейчас, температура и
+// прочие параметры добавляются по мере надобности.
+type CompletionOptions struct {
+	Model       string
+	Temperature float64
+}
+
+// Usage переносит счётчик токенов из usage-блока ответа провайдера в
+// метрики; провайдеры, не отдающие ту или иную величину, оставляют её 0.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// Provider переводит общий prompt в нативный формат конкретного LLM API и
+// возвращает обычный текстовый ответ.
+type Provider interface {
+	// Complete выполняет один запрос на завершение и возвращает текст
+	// ответа и его usage. Ошибка может быть *ProviderError, чтобы
+	// processTask мог отличить ретраибельный сбой (429/5xx) от постоянного.
+	Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, Usage, error)
+	// Configured сообщает, заполнена ли конфигурация провайдера
+	// достаточно, чтобы делать запросы — без обращения к сети.
+	Configured() bool
+}
+
+// ProviderError оборачивает ошибку HTTP-уровня статусом ответа, чтобы
+// вызывающий код мог решить, стоит ли повторять запрос.
+type ProviderError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ProviderError) Error() string { return e.Err.Error() }
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// Retryable верно для 429 и любых 5xx — тех же кодов, что processTask уже
+// считает временными для самого OpenAI.
+func (e *ProviderError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// ProviderConfig описывает блок конфигурации одного провайдера в
+// config.json; какие поля обязательны — зависит от самого провайдера.
+type ProviderConfig struct {
+	APIKey     string `json:"api_key"`
+	BaseURL    string `json:"base_url"`
+	Deployment string `json:"deployment"`
+	APIVersion string `json:"api_version"`
+}
+
+var providerRegistry = map[string]Provider{}
+
+// registerProviders строит реестр провайдеров из конфигурации. Провайдеры
+// без блока конфигурации всё равно регистрируются (используя разумные
+// значения по умолчанию), но останутся Configured() == false.
+func registerProviders(cfg Config) {
+	providerRegistry["openai"] = &openAIProvider{cfg: cfg.Providers["openai"], fallbackAPIKey: cfg.APIKey}
+	providerRegistry["azure"] = &azureProvider{cfg: cfg.Providers["azure"]}
+	providerRegistry["anthropic"] = &anthropicProvider{cfg: cfg.Providers["anthropic"]}
+	providerRegistry["ollama"] = &ollamaProvider{cfg: cfg.Providers["ollama"]}
+}
+
+// resolveProvider возвращает провайдера по имени из Task.Provider, по
+// умолчанию "openai" для обратной совместимости со старыми заданиями.
+func resolveProvider(name string) (Provider, error) {
+	if name == "" {
+		name = "openai"
+	}
+	p, ok := providerRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный провайдер: %s", name)
+	}
+	return p, nil
+}
+
+func httpPostJSON(ctx context.Context, url string, headers map[string]string, body interface{}) ([]byte, error) {
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(bodyJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProviderError{StatusCode: resp.StatusCode, Err: fmt.Errorf("%s: %s", resp.Status, string(responseBody))}
+	}
+
+	return responseBody, nil
+}
+
+// openAIProvider — тот же https://api.openai.com/v1/chat/completions,
+// которым processTask пользовался до появления абстракции Provider.
+type openAIProvider struct {
+	cfg            ProviderConfig
+	fallbackAPIKey string
+}
+
+func (p *openAIProvider) Configured() bool {
+	return p.apiKey() != ""
+}
+
+func (p *openAIProvider) apiKey() string {
+	if p.cfg.APIKey != "" {
+		return p.cfg.APIKey
+	}
+	return p.fallbackAPIKey
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, Usage, error) {
+	model := opts.Model
+	if model == "" {
+		model = "gpt-3.5-turbo"
+	}
+
+	requestData := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"temperature": opts.Temperature,
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey()}
+	responseBody, err := httpPostJSON(ctx, "https://api.openai.com/v1/chat/completions", headers, requestData)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var parsed struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(responseBody, &parsed); err != nil {
+		return "", Usage{}, err
+	}
+	if len(parsed.Choices) == 0 {
+		return "", Usage{}, fmt.Errorf("OpenAI не вернул ни одного choice")
+	}
+
+	usage := Usage{PromptTokens: parsed.Usage.PromptTokens, CompletionTokens: parsed.Usage.CompletionTokens, TotalTokens: parsed.Usage.TotalTokens}
+	return parsed.Choices[0].Message.Content, usage, nil
+}
+
+// azureProvider обращается к тому же chat-completions API, но через
+// deployment-scoped URL Azure OpenAI, с версией API в query-параметре и
+// ключом в заголовке api-key вместо Authorization: Bearer.
+type azureProvider struct {
+	cfg ProviderConfig
+}
+
+func (p *azureProvider) Configured() bool {
+	return p.cfg.APIKey != "" && p.cfg.BaseURL != "" && p.cfg.Deployment != ""
+}
+
+func (p *azureProvider) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, Usage, error) {
+	if !p.Configured() {
+		return "", Usage{}, fmt.Errorf("azure провайдер не сконфигурирован")
+	}
+
+	apiVersion := p.cfg.APIVersion
+	if apiVersion == "" {
+		apiVersion = "2023-05-15"
+	}
+
+	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", p.cfg.BaseURL, p.cfg.Deployment, apiVersion)
+
+	requestData := map[string]interface{}{
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"temperature": opts.Temperature,
+	}
+
+	headers := map[string]string{"api-key": p.cfg.APIKey}
+	responseBody, err := httpPostJSON(ctx, url, headers, requestData)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var parsed struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(responseBody, &parsed); err != nil {
+		return "", Usage{}, err
+	}
+	if len(parsed.Choices) == 0 {
+		return "", Usage{}, fmt.Errorf("Azure OpenAI не вернул ни одного choice")
+	}
+
+	usage := Usage{PromptTokens: parsed.Usage.PromptTokens, CompletionTokens: parsed.Usage.CompletionTokens, TotalTokens: parsed.Usage.TotalTokens}
+	return parsed.Choices[0].Message.Content, usage, nil
+}
+
+// anthropicProvider говорит на языке Messages API: x-api-key вместо
+// Authorization и обязательный заголовок anthropic-version.
+type anthropicProvider struct {
+	cfg ProviderConfig
+}
+
+func (p *anthropicProvider) Configured() bool {
+	return p.cfg.APIKey != ""
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, Usage, error) {
+	if !p.Configured() {
+		return "", Usage{}, fmt.Errorf("anthropic провайдер не сконфигурирован")
+	}
+
+	model := opts.Model
+	if model == "" {
+		model = "claude-3-haiku-20240307"
+	}
+
+	requestData := map[string]interface{}{
+		"model":      model,
+		"max_tokens": 1024,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+	}
+
+	headers := map[string]string{
+		"x-api-key":         p.cfg.APIKey,
+		"anthropic-version": "2023-06-01",
+	}
+	responseBody, err := httpPostJSON(ctx, "https://api.anthropic.com/v1/messages", headers, requestData)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var parsed struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(responseBody, &parsed); err != nil {
+		return "", Usage{}, err
+	}
+	if len(parsed.Content) == 0 {
+		return "", Usage{}, fmt.Errorf("Anthropic не вернул ни одного блока content")
+	}
+
+	usage := Usage{
+		PromptTokens:     parsed.Usage.InputTokens,
+		CompletionTokens: parsed.Usage.OutputTokens,
+		TotalTokens:      parsed.Usage.InputTokens + parsed.Usage.OutputTokens,
+	}
+	return parsed.Content[0].Text, usage, nil
+}
+
+// ollamaProvider обращается к локальному (или настроенному по base_url)
+// серверу Ollama через его /api/generate с выключенным стримингом.
+type ollamaProvider struct {
+	cfg ProviderConfig
+}
+
+func (p *ollamaProvider) Configured() bool {
+	return p.baseURL() != ""
+}
+
+func (p *ollamaProvider) baseURL() string {
+	if p.cfg.BaseURL != "" {
+		return p.cfg.BaseURL
+	}
+	return "http://localhost:11434"
+}
+
+func (p *ollamaProvider) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, Usage, error) {
+	model := opts.Model
+	if model == "" {
+		model = "llama2"
+	}
+
+	requestData := map[string]interface{}{
+		"model":  model,
+		"prompt": prompt,
+		"stream": false,
+	}
+
+	responseBody, err := httpPostJSON(ctx, p.baseURL()+"/api/generate", nil, requestData)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var parsed struct {
+		Response string `json:"response"`
+		// Ollama не считает токены как OpenAI; prompt/eval_count — это её
+		// ближайший аналог prompt/completion tokens.
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	if err := json.Unmarshal(responseBody, &parsed); err != nil {
+		return "", Usage{}, err
+	}
+
+	usage := Usage{
+		PromptTokens:     parsed.PromptEvalCount,
+		CompletionTokens: parsed.EvalCount,
+		TotalTokens:      parsed.PromptEvalCount + parsed.EvalCount,
+	}
+	return parsed.Response, usage, nil
+}
+
+type providerStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+}
+
+// providersHandler перечисляет зарегистрированных провайдеров и то,
+// сконфигурированы ли они — без живого обращения к каждому API.
+func providersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]providerStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, providerStatus{Name: name, Healthy: providerRegistry[name].Configured()})
+	}
+
+	json.NewEncoder(w).Encode(statuses)
+}
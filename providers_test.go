@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAzureProviderCompleteParsesResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("api-key") != "secret" {
+			t.Errorf("api-key = %q, want %q", r.Header.Get("api-key"), "secret")
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"ответ"}}],"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`))
+	}))
+	defer server.Close()
+
+	p := &azureProvider{cfg: ProviderConfig{APIKey: "secret", BaseURL: server.URL, Deployment: "gpt4"}}
+
+	text, usage, err := p.Complete(context.Background(), "привет", CompletionOptions{})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if text != "ответ" {
+		t.Errorf("text = %q, want %q", text, "ответ")
+	}
+	if usage != (Usage{PromptTokens: 3, CompletionTokens: 5, TotalTokens: 8}) {
+		t.Errorf("usage = %+v, want {3 5 8}", usage)
+	}
+}
+
+func TestAzureProviderCompleteNoChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer server.Close()
+
+	p := &azureProvider{cfg: ProviderConfig{APIKey: "secret", BaseURL: server.URL, Deployment: "gpt4"}}
+
+	if _, _, err := p.Complete(context.Background(), "привет", CompletionOptions{}); err == nil {
+		t.Fatal("Complete() error = nil, want error for empty choices")
+	}
+}
+
+func TestAzureProviderNotConfigured(t *testing.T) {
+	p := &azureProvider{cfg: ProviderConfig{APIKey: "secret"}}
+	if p.Configured() {
+		t.Error("Configured() = true, want false without base_url/deployment")
+	}
+	if _, _, err := p.Complete(context.Background(), "привет", CompletionOptions{}); err == nil {
+		t.Fatal("Complete() error = nil, want error when not configured")
+	}
+}
+
+func TestOllamaProviderCompleteParsesResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":"ответ","prompt_eval_count":4,"eval_count":6}`))
+	}))
+	defer server.Close()
+
+	p := &ollamaProvider{cfg: ProviderConfig{BaseURL: server.URL}}
+
+	text, usage, err := p.Complete(context.Background(), "привет", CompletionOptions{})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if text != "ответ" {
+		t.Errorf("text = %q, want %q", text, "ответ")
+	}
+	if usage != (Usage{PromptTokens: 4, CompletionTokens: 6, TotalTokens: 10}) {
+		t.Errorf("usage = %+v, want {4 6 10}", usage)
+	}
+}
+
+func TestOllamaProviderDefaultBaseURL(t *testing.T) {
+	p := &ollamaProvider{}
+	if p.baseURL() != "http://localhost:11434" {
+		t.Errorf("baseURL() = %q, want default", p.baseURL())
+	}
+	if !p.Configured() {
+		t.Error("Configured() = false, want true — ollama работает с дефолтным base_url")
+	}
+}
+
+func TestProviderErrorRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		e := &ProviderError{StatusCode: tt.status}
+		if got := e.Retryable(); got != tt.want {
+			t.Errorf("Retryable() для статуса %d = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestResolveProvider(t *testing.T) {
+	providerRegistry = map[string]Provider{}
+	registerProviders(Config{})
+
+	if _, err := resolveProvider(""); err != nil {
+		t.Errorf("resolveProvider(\"\") error = %v, want nil (должен дать openai по умолчанию)", err)
+	}
+	if _, err := resolveProvider("openai"); err != nil {
+		t.Errorf("resolveProvider(\"openai\") error = %v, want nil", err)
+	}
+	if _, err := resolveProvider("несуществующий"); err == nil {
+		t.Fatal("resolveProvider(\"несуществующий\") error = nil, want error")
+	}
+}
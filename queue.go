@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"math/rand"
+	"time"
+)
+
+const (
+	pollInterval = 500 * time.Millisecond
+	maxAttempts  = 5
+	baseBackoff  = 2 * time.Second
+	capBackoff   = 5 * time.Minute
+)
+
+// runWorker непрерывно забирает задания из БД-очереди и обрабатывает их.
+// В отличие от старого in-memory chan Task, здесь нет потери заданий при
+// перезапуске: состояние очереди целиком живёт в таблице tasks.
+func runWorker() {
+	for {
+		task, err := claimNextTask()
+		if err != nil {
+			logger.Error("не удалось выбрать задание из очереди", "error", err)
+			recordError("queue_claim")
+			time.Sleep(pollInterval)
+			continue
+		}
+		if task == nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+		processTask(*task)
+	}
+}
+
+// claimNextTask атомарно выбирает задание с наивысшим приоритетом, готовое
+// к выполнению (next_run_at уже наступил), и помечает его running.
+//
+// Раньше здесь был SELECT внутри tx, затем отдельный UPDATE ... WHERE id=? —
+// но go-sqlite3 держит пул соединений, и без общего write-lock два воркера
+// читали одну и ту же pending-строку, а второй апдейт внутри своей
+// транзакции падал с SQLITE_BUSY вместо того, чтобы просто не найти строку.
+// Здесь сперва читаем id кандидата вне транзакции, а забираем его condition-update'ом
+// по точному id — если RowsAffected() == 0, значит другой воркер уже забрал
+// эту строку между SELECT и UPDATE, и мы просто отдаём задание следующему опросу.
+func claimNextTask() (*Task, error) {
+	var taskID int
+	err := db.QueryRow(`SELECT id FROM tasks
+        WHERE status = 'pending' AND next_run_at <= CURRENT_TIMESTAMP
+        ORDER BY priority DESC, id ASC
+        LIMIT 1`).Scan(&taskID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result, err := db.Exec("UPDATE tasks SET status = 'running' WHERE id = ? AND status = 'pending'", taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, nil
+	}
+
+	var task Task
+	err = db.QueryRow(`SELECT id, item_id, prompt, response, model, provider, priority
+        FROM tasks WHERE id = ?`, taskID).Scan(&task.ID, &task.ItemId, &task.Prompt, &task.Response, &task.Model, &task.Provider, &task.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	task.Status = "running"
+	return &task, nil
+}
+
+// completeTask фиксирует успешный ответ и переводит задание в done.
+func completeTask(task Task) {
+	stmt, err := db.Prepare("UPDATE tasks SET response = ?, processed = 1, status = 'done' WHERE id = ?")
+	if err != nil {
+		logger.Error("не удалось обновить задание в базе данных", "task_id", task.ID, "error", err)
+		return
+	}
+	if _, err := stmt.Exec(task.Response, task.ID); err != nil {
+		logger.Error("не удалось обновить задание в базе данных", "task_id", task.ID, "error", err)
+	}
+}
+
+// failTask обрабатывает неудачную попытку: если ошибка ретраибельна
+// (429/5xx/сетевая) и лимит попыток не исчерпан — переносит задание обратно
+// в pending с экспоненциальной задержкой и джиттером; иначе помечает его
+// failed.
+func failTask(task Task, errMsg string, retryable bool) {
+	var attempts int
+	if err := db.QueryRow("SELECT attempts FROM tasks WHERE id = ?", task.ID).Scan(&attempts); err != nil {
+		logger.Error("не удалось прочитать число попыток", "task_id", task.ID, "error", err)
+		attempts = 0
+	}
+	attempts++
+
+	if !retryable || attempts >= maxAttempts {
+		_, err := db.Exec("UPDATE tasks SET status = 'failed', attempts = ?, last_error = ? WHERE id = ?", attempts, errMsg, task.ID)
+		if err != nil {
+			logger.Error("не удалось пометить задание неудавшимся", "task_id", task.ID, "error", err)
+		}
+		return
+	}
+
+	nextRunAt := time.Now().Add(backoffDuration(attempts))
+	_, err := db.Exec(`UPDATE tasks SET status = 'pending', attempts = ?, last_error = ?, next_run_at = ? WHERE id = ?`,
+		attempts, errMsg, nextRunAt.UTC().Format("2006-01-02 15:04:05"), task.ID)
+	if err != nil {
+		logger.Error("не удалось запланировать повтор задания", "task_id", task.ID, "error", err)
+	}
+}
+
+// backoffDuration считает экспоненциальную задержку с джиттером и
+// ограничением сверху, чтобы не заваливать OpenAI ретраями после 429/5xx.
+func backoffDuration(attempts int) time.Duration {
+	d := baseBackoff * time.Duration(1<<uint(attempts))
+	if d <= 0 || d > capBackoff {
+		d = capBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/4 + 1))
+	return d + jitter
+}
+
+// recoverInFlightTasks возвращает в очередь задания, которые остались в
+// статусе running после неожиданной остановки процесса.
+func recoverInFlightTasks() error {
+	_, err := db.Exec("UPDATE tasks SET status = 'pending' WHERE status = 'running'")
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestBackoffDurationCapAndJitter(t *testing.T) {
+	for attempts := 1; attempts <= 10; attempts++ {
+		d := backoffDuration(attempts)
+
+		if d <= 0 {
+			t.Fatalf("backoffDuration(%d) = %v, want > 0", attempts, d)
+		}
+
+		// Джиттер добавляет до base/4 + 1, поэтому даже когда база уже
+		// упёрлась в capBackoff, итог не может превышать cap + cap/4 + 1.
+		maxWithJitter := capBackoff + capBackoff/4 + 1
+		if d > maxWithJitter {
+			t.Errorf("backoffDuration(%d) = %v, превышает cap+jitter %v", attempts, d, maxWithJitter)
+		}
+	}
+}
+
+func TestBackoffDurationBelowCapStaysCloseToBase(t *testing.T) {
+	// На малых attempts база 2*2^attempts секунд ещё не достигла
+	// capBackoff (5 минут), поэтому итог должен лежать между базой и
+	// базой с максимальным джиттером, а не быть обрезан по cap.
+	attempts := 2
+	base := baseBackoff * (1 << uint(attempts))
+	maxWithJitter := base + base/4 + 1
+
+	for i := 0; i < 20; i++ {
+		d := backoffDuration(attempts)
+		if d < base || d > maxWithJitter {
+			t.Fatalf("backoffDuration(%d) = %v, want in [%v, %v]", attempts, d, base, maxWithJitter)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// writeSSEData пишет один SSE-event с полем data. Токен может содержать
+// переводы строк (код, списки, проза) — по спецификации SSE каждая
+// физическая строка значения data должна идти отдельным полем "data: ",
+// иначе клиент молча отбрасывает всё после первого \n.
+func writeSSEData(w http.ResponseWriter, token string) {
+	for _, line := range strings.Split(token, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// parseSSELine разбирает одну строку потока OpenAI. ok == false значит, что
+// строку нужно просто пропустить (не data:-поле, пустой choices или делта
+// без контента); done == true сигнализирует терминальное "data: [DONE]".
+func parseSSELine(line string) (token string, done bool, ok bool) {
+	if !strings.HasPrefix(line, "data: ") {
+		return "", false, false
+	}
+
+	data := strings.TrimPrefix(line, "data: ")
+	if data == "[DONE]" {
+		return "", true, false
+	}
+
+	var chunk streamChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return "", false, false
+	}
+	if len(chunk.Choices) == 0 {
+		return "", false, false
+	}
+
+	token = chunk.Choices[0].Delta.Content
+	if token == "" {
+		return "", false, false
+	}
+
+	return token, false, true
+}
+
+// streamTaskHandler открывает SSE-соединение с клиентом и транслирует токены
+// ответа OpenAI по мере их поступления, сохраняя итоговый текст в tasks.
+func streamTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var task Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		sendJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// status='streaming' не входит в WHERE status='pending' в claimNextTask,
+	// иначе runWorker подхватит эту же строку и задаст тот же prompt
+	// отдельным, не потоковым запросом — второй платный вызов OpenAI,
+	// гоняющийся с этим хендлером за tasks.response.
+	stmt, err := db.Prepare("INSERT INTO tasks (prompt, item_id, response, processed, status) VALUES (?, ?, '', 0, 'streaming')")
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result, err := stmt.Exec(task.Prompt, task.ItemId)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	taskID, _ := result.LastInsertId()
+	task.ID = int(taskID)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendJSONError(w, http.StatusInternalServerError, "Стриминг не поддерживается")
+		return
+	}
+
+	requestData := map[string]interface{}{
+		"model": "gpt-3.5-turbo",
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": task.Prompt,
+			},
+		},
+		"temperature": 0.7,
+		"stream":      true,
+	}
+
+	requestDataJSON, err := json.Marshal(requestData)
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestDataJSON))
+	if err != nil {
+		sendJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("ошибка при выполнении потокового запроса", "task_id", task.ID, "error", err)
+		sendJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("ошибка HTTP статуса при стриминге", "task_id", task.ID, "status", resp.Status)
+		sendJSONError(w, resp.StatusCode, resp.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var fullResponse strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			logger.Warn("клиент отключился, прерываем задание", "task_id", task.ID)
+			return
+		default:
+		}
+
+		token, done, ok := parseSSELine(scanner.Text())
+		if done {
+			break
+		}
+		if !ok {
+			continue
+		}
+
+		fullResponse.WriteString(token)
+		writeSSEData(w, token)
+		flusher.Flush()
+	}
+
+	task.Response = fullResponse.String()
+
+	updateStmt, err := db.Prepare("UPDATE tasks SET response = ?, processed = 1, status = 'done' WHERE id = ?")
+	if err != nil {
+		logger.Error("не удалось обновить задание в базе данных", "task_id", task.ID, "error", err)
+		return
+	}
+	if _, err := updateStmt.Exec(task.Response, task.ID); err != nil {
+		logger.Error("не удалось обновить задание в базе данных", "task_id", task.ID, "error", err)
+	}
+}
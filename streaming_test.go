@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseSSELine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantToken string
+		wantDone  bool
+		wantOK    bool
+	}{
+		{
+			name:   "не data-поле пропускается",
+			line:   "event: ping",
+			wantOK: false,
+		},
+		{
+			name:     "[DONE] завершает поток",
+			line:     "data: [DONE]",
+			wantDone: true,
+		},
+		{
+			name:      "обычная дельта с контентом",
+			line:      `data: {"choices":[{"delta":{"content":"привет"}}]}`,
+			wantToken: "привет",
+			wantOK:    true,
+		},
+		{
+			name:   "дельта без choices пропускается",
+			line:   `data: {"choices":[]}`,
+			wantOK: false,
+		},
+		{
+			name:   "дельта с пустым content пропускается",
+			line:   `data: {"choices":[{"delta":{"content":""}}]}`,
+			wantOK: false,
+		},
+		{
+			name:   "битый JSON пропускается без паники",
+			line:   `data: {not json}`,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, done, ok := parseSSELine(tt.line)
+			if token != tt.wantToken || done != tt.wantDone || ok != tt.wantOK {
+				t.Errorf("parseSSELine(%q) = (%q, %v, %v), want (%q, %v, %v)",
+					tt.line, token, done, ok, tt.wantToken, tt.wantDone, tt.wantOK)
+			}
+		})
+	}
+}